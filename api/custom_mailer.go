@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/netlify/gotrue/hooks"
+	"github.com/netlify/gotrue/mailer"
+	"github.com/netlify/gotrue/models"
+)
+
+// CustomMailer implements mailer.Mailer by dispatching every outgoing
+// transactional email to the configured send-email hook instead of sending
+// it directly, so an operator can fully own delivery and templating. Link
+// generation and address validation are left to the wrapped default mailer
+// since they aren't part of what the hook payload carries.
+type CustomMailer struct {
+	mailer.Mailer
+	ctx        context.Context
+	siteURL    string
+	dispatcher *hooks.SendEmailDispatcher
+}
+
+func NewCustomMailer(ctx context.Context, siteURL string, defaultMailer mailer.Mailer, dispatcher *hooks.SendEmailDispatcher) *CustomMailer {
+	return &CustomMailer{
+		Mailer:     defaultMailer,
+		ctx:        ctx,
+		siteURL:    siteURL,
+		dispatcher: dispatcher,
+	}
+}
+
+func (m *CustomMailer) ConfirmationMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return m.send(user, "signup", referrerURL, externalURL, hooks.EmailData{
+		Token:     otp,
+		TokenHash: fmt.Sprintf("%x", sha256.Sum224([]byte(user.GetEmail()+otp))),
+	})
+}
+
+func (m *CustomMailer) InviteMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return m.send(user, "invite", referrerURL, externalURL, hooks.EmailData{
+		Token:     otp,
+		TokenHash: fmt.Sprintf("%x", sha256.Sum224([]byte(user.GetEmail()+otp))),
+	})
+}
+
+func (m *CustomMailer) RecoveryMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return m.send(user, "recovery", referrerURL, externalURL, hooks.EmailData{
+		Token:     otp,
+		TokenHash: fmt.Sprintf("%x", sha256.Sum224([]byte(user.GetEmail()+otp))),
+	})
+}
+
+func (m *CustomMailer) MagicLinkMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return m.send(user, "magiclink", referrerURL, externalURL, hooks.EmailData{
+		Token:     otp,
+		TokenHash: fmt.Sprintf("%x", sha256.Sum224([]byte(user.GetEmail()+otp))),
+	})
+}
+
+func (m *CustomMailer) EmailChangeMail(user *models.User, otpNew, otpCurrent, referrerURL, externalURL string) error {
+	return m.send(user, "email_change", referrerURL, externalURL, hooks.EmailData{
+		Token:        otpCurrent,
+		TokenHash:    fmt.Sprintf("%x", sha256.Sum224([]byte(user.GetEmail()+otpCurrent))),
+		TokenNew:     otpNew,
+		TokenHashNew: fmt.Sprintf("%x", sha256.Sum224([]byte(user.EmailChange+otpNew))),
+	})
+}
+
+func (m *CustomMailer) ReauthenticateMail(user *models.User, otp string) error {
+	return m.send(user, "reauthentication", "", m.siteURL, hooks.EmailData{
+		Token:     otp,
+		TokenHash: fmt.Sprintf("%x", sha256.Sum224([]byte(user.GetEmail()+otp))),
+	})
+}
+
+func (m *CustomMailer) send(user *models.User, actionType, referrerURL, externalURL string, emailData hooks.EmailData) error {
+	emailData.EmailActionType = actionType
+	emailData.RedirectTo = referrerURL
+	emailData.SiteURL = externalURL
+
+	_, err := m.dispatcher.Dispatch(m.ctx, &hooks.SendEmailInput{
+		User:      user,
+		EmailData: emailData,
+	})
+	return err
+}
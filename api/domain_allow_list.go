@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/netlify/gotrue/conf"
+)
+
+type externalURLContextKey struct{}
+
+var domainAllowList conf.DomainAllowList
+
+// domainAllowListHandler parses config.DomainAllowList once, at the time
+// this middleware is installed, and returns the parse error (if any) so API
+// startup fails loudly on a malformed list instead of silently disabling
+// the feature - the old sync.Once swallowed that error, permanently, since
+// a successful Do() call never runs its function again regardless of what
+// it returned.
+func (a *API) domainAllowListHandler() (func(http.Handler) http.Handler, error) {
+	allowList, err := a.config.ParseDomainAllowList()
+	if err != nil {
+		return nil, err
+	}
+	domainAllowList = allowList
+
+	return newDomainAllowListMiddleware, nil
+}
+
+// newDomainAllowListMiddleware inspects the incoming Host (or
+// X-Forwarded-Host, for deployments behind a proxy) and, if it matches an
+// entry in the parsed allow list, stashes that entry's external URL in the
+// request context for getExternalURL to pick up. Requests from hosts
+// outside the allow list fall back to API.ExternalURL, same as before this
+// existed.
+func newDomainAllowListMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+
+		ctx := r.Context()
+		if matched, ok := domainAllowList.Match(host); ok {
+			ctx = context.WithValue(ctx, externalURLContextKey{}, matched.String())
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isRedirectToAllowed reports whether redirectTo is empty, targets a host
+// on the domain allow list, or (when the allow list is empty) is always
+// allowed - so tokens emailed for one custom domain always land back on
+// that same domain.
+func isRedirectToAllowed(redirectTo string) bool {
+	if redirectTo == "" || len(domainAllowList) == 0 {
+		return true
+	}
+	u, err := url.Parse(redirectTo)
+	if err != nil {
+		return false
+	}
+	_, ok := domainAllowList.Match(u.Hostname())
+	return ok
+}
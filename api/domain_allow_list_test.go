@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/netlify/gotrue/conf"
+)
+
+func TestDomainAllowListMiddlewareStashesMatchedExternalURL(t *testing.T) {
+	matched, err := url.Parse("https://custom.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := domainAllowList
+	domainAllowList = conf.DomainAllowList{"custom.example.com": matched}
+	defer func() { domainAllowList = original }()
+
+	var gotExternalURL interface{}
+	handler := newDomainAllowListMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotExternalURL = r.Context().Value(externalURLContextKey{})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "custom.example.com"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotExternalURL != matched.String() {
+		t.Errorf("externalURLContextKey = %v, want %v", gotExternalURL, matched.String())
+	}
+}
+
+func TestDomainAllowListMiddlewareIgnoresUnmatchedHost(t *testing.T) {
+	original := domainAllowList
+	domainAllowList = conf.DomainAllowList{}
+	defer func() { domainAllowList = original }()
+
+	handler := newDomainAllowListMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Context().Value(externalURLContextKey{}); v != nil {
+			t.Errorf("expected no stashed external URL, got %v", v)
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "unrelated.example.com"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestDomainAllowListMiddlewareUsesForwardedHost(t *testing.T) {
+	matched, err := url.Parse("https://proxied.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := domainAllowList
+	domainAllowList = conf.DomainAllowList{"proxied.example.com": matched}
+	defer func() { domainAllowList = original }()
+
+	var gotExternalURL interface{}
+	handler := newDomainAllowListMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotExternalURL = r.Context().Value(externalURLContextKey{})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "direct.example.com"
+	req.Header.Set("X-Forwarded-Host", "proxied.example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotExternalURL != matched.String() {
+		t.Errorf("externalURLContextKey = %v, want %v", gotExternalURL, matched.String())
+	}
+}
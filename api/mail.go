@@ -9,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/netlify/gotrue/conf"
 	"github.com/netlify/gotrue/crypto"
 	"github.com/netlify/gotrue/mailer"
 	"github.com/netlify/gotrue/models"
@@ -22,6 +21,12 @@ var (
 	MaxFrequencyLimitError error = errors.New("frequency limit reached")
 )
 
+// tokenStore is the unified backing store for confirmation/recovery/invite/
+// reauthentication/email-change tokens. Every send* helper writes to it in
+// addition to the legacy hashed columns on users, which are kept as a
+// compatibility read path for the duration of the migration window.
+var tokenStore = models.NewTokenStore()
+
 type GenerateLinkParams struct {
 	Type       string                 `json:"type"`
 	Email      string                 `json:"email"`
@@ -81,6 +86,10 @@ func (a *API) GenerateLink(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	if params.RedirectTo != "" && !isRedirectToAllowed(params.RedirectTo) {
+		return unprocessableEntityError("Invalid redirect_to: host is not on the domain allow list")
+	}
+
 	var url string
 	referrer := a.getRedirectURLOrReferrer(r, params.RedirectTo)
 	now := time.Now()
@@ -197,7 +206,7 @@ func (a *API) GenerateLink(w http.ResponseWriter, r *http.Request) error {
 			return terr
 		}
 
-		url, terr = mailer.GetEmailActionLink(user, params.Type, referrer)
+		url, terr = mailer.GetEmailActionLink(user, params.Type, referrer, a.getExternalURL(ctx))
 		if terr != nil {
 			return terr
 		}
@@ -220,9 +229,20 @@ func (a *API) GenerateLink(w http.ResponseWriter, r *http.Request) error {
 	return sendJSON(w, http.StatusOK, resp)
 }
 
-func sendConfirmation(tx *storage.Connection, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, referrerURL string, otpLength int) error {
-	var err error
-	if u.ConfirmationSentAt != nil && !u.ConfirmationSentAt.Add(maxFrequency).Before(time.Now()) {
+// isSentWithinFrequency reports whether sentAt is recent enough that another
+// send of the same kind should be throttled.
+func isSentWithinFrequency(sentAt *time.Time, maxFrequency time.Duration) bool {
+	return sentAt != nil && !sentAt.Add(maxFrequency).Before(time.Now())
+}
+
+func (a *API) sendConfirmation(r *http.Request, tx *storage.Connection, u *models.User, referrerURL string) error {
+	ctx := r.Context()
+	mailer := a.Mailer(ctx)
+	otpLength := a.config.Mailer.OtpLength
+	maxFrequency := a.config.Mailer.MaxFrequency
+	externalURL := a.getExternalURL(ctx)
+
+	if isSentWithinFrequency(u.ConfirmationSentAt, maxFrequency) {
 		return MaxFrequencyLimitError
 	}
 	oldToken := u.ConfirmationToken
@@ -232,16 +252,26 @@ func sendConfirmation(tx *storage.Connection, u *models.User, mailer mailer.Mail
 	}
 	u.ConfirmationToken = fmt.Sprintf("%x", sha256.Sum224([]byte(u.GetEmail()+otp)))
 	now := time.Now()
-	if err := mailer.ConfirmationMail(u, otp, referrerURL); err != nil {
+	if !allowEmailSend(ctx) {
+		return tooManyRequestsError
+	}
+	if err := mailer.ConfirmationMail(u, otp, referrerURL, externalURL); err != nil {
 		u.ConfirmationToken = oldToken
 		return errors.Wrap(err, "Error sending confirmation email")
 	}
 	u.ConfirmationSentAt = &now
+	if _, terr := tokenStore.Create(tx, u, models.ConfirmationToken, u.ConfirmationToken, nil, a.config.Mailer.TokenTTL); terr != nil {
+		return errors.Wrap(terr, "Database error creating confirmation token")
+	}
 	return errors.Wrap(tx.UpdateOnly(u, "confirmation_token", "confirmation_sent_at"), "Database error updating user for confirmation")
 }
 
-func sendInvite(tx *storage.Connection, u *models.User, mailer mailer.Mailer, referrerURL string, otpLength int) error {
-	var err error
+func (a *API) sendInvite(r *http.Request, tx *storage.Connection, u *models.User, referrerURL string) error {
+	ctx := r.Context()
+	mailer := a.Mailer(ctx)
+	otpLength := a.config.Mailer.OtpLength
+	externalURL := a.getExternalURL(ctx)
+
 	oldToken := u.ConfirmationToken
 	otp, err := crypto.GenerateOtp(otpLength)
 	if err != nil {
@@ -249,18 +279,29 @@ func sendInvite(tx *storage.Connection, u *models.User, mailer mailer.Mailer, re
 	}
 	u.ConfirmationToken = fmt.Sprintf("%x", sha256.Sum224([]byte(u.GetEmail()+otp)))
 	now := time.Now()
-	if err := mailer.InviteMail(u, otp, referrerURL); err != nil {
+	if !allowEmailSend(ctx) {
+		return tooManyRequestsError
+	}
+	if err := mailer.InviteMail(u, otp, referrerURL, externalURL); err != nil {
 		u.ConfirmationToken = oldToken
 		return errors.Wrap(err, "Error sending invite email")
 	}
 	u.InvitedAt = &now
 	u.ConfirmationSentAt = &now
+	if _, terr := tokenStore.Create(tx, u, models.InviteToken, u.ConfirmationToken, nil, a.config.Mailer.TokenTTL); terr != nil {
+		return errors.Wrap(terr, "Database error creating invite token")
+	}
 	return errors.Wrap(tx.UpdateOnly(u, "confirmation_token", "confirmation_sent_at", "invited_at"), "Database error updating user for invite")
 }
 
-func (a *API) sendPasswordRecovery(tx *storage.Connection, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, referrerURL string, otpLength int) error {
-	var err error
-	if u.RecoverySentAt != nil && !u.RecoverySentAt.Add(maxFrequency).Before(time.Now()) {
+func (a *API) sendPasswordRecovery(r *http.Request, tx *storage.Connection, u *models.User, referrerURL string) error {
+	ctx := r.Context()
+	mailer := a.Mailer(ctx)
+	otpLength := a.config.Mailer.OtpLength
+	maxFrequency := a.config.Mailer.MaxFrequency
+	externalURL := a.getExternalURL(ctx)
+
+	if isSentWithinFrequency(u.RecoverySentAt, maxFrequency) {
 		return MaxFrequencyLimitError
 	}
 
@@ -271,17 +312,27 @@ func (a *API) sendPasswordRecovery(tx *storage.Connection, u *models.User, maile
 	}
 	u.RecoveryToken = fmt.Sprintf("%x", sha256.Sum224([]byte(u.GetEmail()+otp)))
 	now := time.Now()
-	if err := mailer.RecoveryMail(u, otp, referrerURL); err != nil {
+	if !allowEmailSend(ctx) {
+		return tooManyRequestsError
+	}
+	if err := mailer.RecoveryMail(u, otp, referrerURL, externalURL); err != nil {
 		u.RecoveryToken = oldToken
 		return errors.Wrap(err, "Error sending recovery email")
 	}
 	u.RecoverySentAt = &now
+	if _, terr := tokenStore.Create(tx, u, models.RecoveryToken, u.RecoveryToken, nil, a.config.Mailer.TokenTTL); terr != nil {
+		return errors.Wrap(terr, "Database error creating recovery token")
+	}
 	return errors.Wrap(tx.UpdateOnly(u, "recovery_token", "recovery_sent_at"), "Database error updating user for recovery")
 }
 
-func (a *API) sendReauthenticationOtp(tx *storage.Connection, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, otpLength int) error {
-	var err error
-	if u.ReauthenticationSentAt != nil && !u.ReauthenticationSentAt.Add(maxFrequency).Before(time.Now()) {
+func (a *API) sendReauthenticationOtp(r *http.Request, tx *storage.Connection, u *models.User) error {
+	ctx := r.Context()
+	mailer := a.Mailer(ctx)
+	otpLength := a.config.Mailer.OtpLength
+	maxFrequency := a.config.Mailer.MaxFrequency
+
+	if isSentWithinFrequency(u.ReauthenticationSentAt, maxFrequency) {
 		return MaxFrequencyLimitError
 	}
 
@@ -291,23 +342,31 @@ func (a *API) sendReauthenticationOtp(tx *storage.Connection, u *models.User, ma
 		return err
 	}
 	u.ReauthenticationToken = fmt.Sprintf("%x", sha256.Sum224([]byte(u.GetEmail()+otp)))
-	if err != nil {
-		return err
-	}
 	now := time.Now()
+	if !allowEmailSend(ctx) {
+		return tooManyRequestsError
+	}
 	if err := mailer.ReauthenticateMail(u, otp); err != nil {
 		u.ReauthenticationToken = oldToken
 		return errors.Wrap(err, "Error sending reauthentication email")
 	}
 	u.ReauthenticationSentAt = &now
+	if _, terr := tokenStore.Create(tx, u, models.ReauthenticationToken, u.ReauthenticationToken, nil, a.config.Mailer.TokenTTL); terr != nil {
+		return errors.Wrap(terr, "Database error creating reauthentication token")
+	}
 	return errors.Wrap(tx.UpdateOnly(u, "reauthentication_token", "reauthentication_sent_at"), "Database error updating user for reauthentication")
 }
 
-func (a *API) sendMagicLink(tx *storage.Connection, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, referrerURL string, otpLength int) error {
-	var err error
+func (a *API) sendMagicLink(r *http.Request, tx *storage.Connection, u *models.User, referrerURL string) error {
+	ctx := r.Context()
+	mailer := a.Mailer(ctx)
+	otpLength := a.config.Mailer.OtpLength
+	maxFrequency := a.config.Mailer.MaxFrequency
+	externalURL := a.getExternalURL(ctx)
+
 	// since Magic Link is just a recovery with a different template and behaviour
 	// around new users we will reuse the recovery db timer to prevent potential abuse
-	if u.RecoverySentAt != nil && !u.RecoverySentAt.Add(maxFrequency).Before(time.Now()) {
+	if isSentWithinFrequency(u.RecoverySentAt, maxFrequency) {
 		return MaxFrequencyLimitError
 	}
 	oldToken := u.RecoveryToken
@@ -317,17 +376,28 @@ func (a *API) sendMagicLink(tx *storage.Connection, u *models.User, mailer maile
 	}
 	u.RecoveryToken = fmt.Sprintf("%x", sha256.Sum224([]byte(u.GetEmail()+otp)))
 	now := time.Now()
-	if err := mailer.MagicLinkMail(u, otp, referrerURL); err != nil {
+	if !allowEmailSend(ctx) {
+		return tooManyRequestsError
+	}
+	if err := mailer.MagicLinkMail(u, otp, referrerURL, externalURL); err != nil {
 		u.RecoveryToken = oldToken
 		return errors.Wrap(err, "Error sending magic link email")
 	}
 	u.RecoverySentAt = &now
+	if _, terr := tokenStore.Create(tx, u, models.RecoveryToken, u.RecoveryToken, nil, a.config.Mailer.TokenTTL); terr != nil {
+		return errors.Wrap(terr, "Database error creating magic link token")
+	}
 	return errors.Wrap(tx.UpdateOnly(u, "recovery_token", "recovery_sent_at"), "Database error updating user for recovery")
 }
 
 // sendEmailChange sends out an email change token to the new email.
-func (a *API) sendEmailChange(tx *storage.Connection, config *conf.GlobalConfiguration, u *models.User, mailer mailer.Mailer, email string, referrerURL string, otpLength int) error {
-	var err error
+func (a *API) sendEmailChange(r *http.Request, tx *storage.Connection, u *models.User, email, referrerURL string) error {
+	ctx := r.Context()
+	mailer := a.Mailer(ctx)
+	config := a.config
+	otpLength := config.Mailer.OtpLength
+	externalURL := a.getExternalURL(ctx)
+
 	otpNew, err := crypto.GenerateOtp(otpLength)
 	if err != nil {
 		return err
@@ -341,18 +411,26 @@ func (a *API) sendEmailChange(tx *storage.Connection, config *conf.GlobalConfigu
 			return err
 		}
 		u.EmailChangeTokenCurrent = fmt.Sprintf("%x", sha256.Sum224([]byte(u.GetEmail()+otpCurrent)))
-		if err != nil {
-			return err
-		}
 	}
 	u.EmailChange = email
 	u.EmailChangeConfirmStatus = zeroConfirmation
 	now := time.Now()
-	if err := mailer.EmailChangeMail(u, otpNew, otpCurrent, referrerURL); err != nil {
+	if !allowEmailSend(ctx) {
+		return tooManyRequestsError
+	}
+	if err := mailer.EmailChangeMail(u, otpNew, otpCurrent, referrerURL, externalURL); err != nil {
 		return err
 	}
 
 	u.EmailChangeSentAt = &now
+	if _, terr := tokenStore.Create(tx, u, models.EmailChangeNewToken, u.EmailChangeTokenNew, models.JSONMap{"email": email}, config.Mailer.TokenTTL); terr != nil {
+		return errors.Wrap(terr, "Database error creating email change token")
+	}
+	if u.EmailChangeTokenCurrent != "" {
+		if _, terr := tokenStore.Create(tx, u, models.EmailChangeCurrentToken, u.EmailChangeTokenCurrent, models.JSONMap{"email": email}, config.Mailer.TokenTTL); terr != nil {
+			return errors.Wrap(terr, "Database error creating email change token")
+		}
+	}
 	return errors.Wrap(tx.UpdateOnly(
 		u,
 		"email_change_token_current",
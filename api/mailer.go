@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+
+	"github.com/netlify/gotrue/hooks"
+	"github.com/netlify/gotrue/mailer"
+)
+
+// Mailer returns the mailer.Mailer implementation used to send outgoing
+// transactional email for this request. When the send-email hook is
+// enabled, it returns a CustomMailer that dispatches to the configured
+// webhook/pg-function instead of the default SMTP mailer.
+func (a *API) Mailer(ctx context.Context) mailer.Mailer {
+	config := a.config
+	defaultMailer := mailer.NewMailer(config)
+
+	if !config.Hook.SendEmail.Enabled {
+		return defaultMailer
+	}
+
+	dispatcher := hooks.NewSendEmailDispatcher(&config.Hook.SendEmail, a.db)
+	return NewCustomMailer(ctx, config.API.ExternalURL, defaultMailer, dispatcher)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/gofrs/uuid"
+	"github.com/netlify/gotrue/models"
+	"github.com/netlify/gotrue/storage"
+	"github.com/pquerna/otp/totp"
+)
+
+// ChallengeFactorResponse is returned by the "create a challenge" step of
+// MFA verification; challengeID is then handed back on the verify step.
+type ChallengeFactorResponse struct {
+	ID        uuid.UUID `json:"id"`
+	FactorID  uuid.UUID `json:"factor_id"`
+	ExpiresAt string    `json:"expires_at"`
+}
+
+// VerifyFactorParams are the parameters for the "verify a challenge" step.
+type VerifyFactorParams struct {
+	ChallengeID uuid.UUID `json:"challenge_id"`
+	Code        string    `json:"code"`
+}
+
+func (a *API) loadFactor(r *http.Request) (*models.Factor, error) {
+	factorID, err := uuid.FromString(chi.URLParam(r, "factor_id"))
+	if err != nil {
+		return nil, badRequestError("factor_id must be a UUID")
+	}
+	factor, err := models.FindFactorByFactorID(a.db, factorID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, notFoundError("Factor not found")
+		}
+		return nil, internalServerError("Database error finding factor").WithInternalError(err)
+	}
+	return factor, nil
+}
+
+// ChallengeFactor issues a new Challenge for a factor, the first step of
+// the two-step MFA verification flow.
+func (a *API) ChallengeFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	factor, err := a.loadFactor(r)
+	if err != nil {
+		return err
+	}
+
+	var challenge *models.Challenge
+	err = db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		challenge, terr = factor.CreateChallenge(tx, r.RemoteAddr, a.config.MFA.ChallengeExpiryDuration)
+		return terr
+	})
+	if err != nil {
+		return internalServerError("Database error creating challenge").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, ChallengeFactorResponse{
+		ID:        challenge.ID,
+		FactorID:  challenge.FactorID,
+		ExpiresAt: challenge.ExpiresAt.Format(http.TimeFormat),
+	})
+}
+
+// VerifyFactor verifies a previously issued challenge, the second step of
+// the two-step MFA verification flow.
+func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	factor, err := a.loadFactor(r)
+	if err != nil {
+		return err
+	}
+
+	params := &VerifyFactorParams{}
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return badRequestError("Could not read body").WithInternalError(err)
+	}
+	if err := json.Unmarshal(body, params); err != nil {
+		return badRequestError("Could not parse JSON: %v", err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		challenge, terr := models.FindChallengeByID(tx, params.ChallengeID)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				return notFoundError("Challenge not found")
+			}
+			return internalServerError("Database error finding challenge").WithInternalError(terr)
+		}
+		if challenge.FactorID != factor.ID {
+			return notFoundError("Challenge not found")
+		}
+
+		if !totp.Validate(params.Code, factor.Secret) {
+			// Record the failed attempt on its own connection rather than tx:
+			// this transaction is about to be rolled back by the error we
+			// return below, and the attempt budget only does anything if it
+			// survives that rollback.
+			if terr := challenge.RecordFailedAttempt(a.db.WithContext(ctx)); terr != nil {
+				return unprocessableEntityError(terr.Error())
+			}
+			return unprocessableEntityError("Invalid MFA code")
+		}
+
+		if terr := challenge.Verify(tx); terr != nil {
+			return unprocessableEntityError(terr.Error())
+		}
+		if terr := factor.UpdateStatus(tx, models.FactorStateVerified); terr != nil {
+			return terr
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, factor)
+}
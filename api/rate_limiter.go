@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type emailRateLimitContextKey struct{}
+type smsRateLimitContextKey struct{}
+
+var (
+	emailLimiterOnce sync.Once
+	emailLimiter     *rate.Limiter
+
+	smsLimiterOnce sync.Once
+	smsLimiter     *rate.Limiter
+)
+
+// limitEmailOrPhoneSentHandler builds the shared email/SMS token-bucket
+// limiters (once, from config) and stashes them in the request context so
+// the send* helpers can charge against them right before dispatch, instead
+// of this middleware charging them unconditionally for every request that
+// merely reaches the handler.
+func (a *API) limitEmailOrPhoneSentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A configured rate of 0 is left as a nil limiter rather than passed
+		// to rate.Every (which would divide by zero): allowEmailSend and
+		// allowSmsSend already treat a nil limiter as "unlimited", so this
+		// also doubles as how an operator disables the limiter entirely.
+		emailLimiterOnce.Do(func() {
+			if a.config.RateLimitEmailSent > 0 {
+				emailLimiter = rate.NewLimiter(rate.Every(time.Hour/time.Duration(a.config.RateLimitEmailSent)), a.config.RateLimitEmailSent)
+			}
+		})
+		smsLimiterOnce.Do(func() {
+			if a.config.RateLimitSmsSent > 0 {
+				smsLimiter = rate.NewLimiter(rate.Every(time.Hour/time.Duration(a.config.RateLimitSmsSent)), a.config.RateLimitSmsSent)
+			}
+		})
+
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, emailRateLimitContextKey{}, emailLimiter)
+		ctx = context.WithValue(ctx, smsRateLimitContextKey{}, smsLimiter)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getEmailRateLimiter(ctx context.Context) *rate.Limiter {
+	limiter, _ := ctx.Value(emailRateLimitContextKey{}).(*rate.Limiter)
+	return limiter
+}
+
+func getSmsRateLimiter(ctx context.Context) *rate.Limiter {
+	limiter, _ := ctx.Value(smsRateLimitContextKey{}).(*rate.Limiter)
+	return limiter
+}
+
+// allowEmailSend reports whether an email may be sent, consuming a token
+// from the shared limiter if one has been set up for this request.
+func allowEmailSend(ctx context.Context) bool {
+	if limiter := getEmailRateLimiter(ctx); limiter != nil {
+		return limiter.Allow()
+	}
+	return true
+}
+
+// allowSmsSend reports whether an SMS may be sent, consuming a token from
+// the shared limiter if one has been set up for this request. No send*
+// helper calls this yet: this tree has no phone/SMS signup or OTP send
+// path to charge against, only the email one limitEmailOrPhoneSentHandler
+// and the send* helpers in mail.go exercise. It's wired up to the same
+// context-stashed limiter as allowEmailSend so that whichever handler
+// eventually dispatches an SMS only has to call it, exactly as the email
+// helpers already do.
+func allowSmsSend(ctx context.Context) bool {
+	if limiter := getSmsRateLimiter(ctx); limiter != nil {
+		return limiter.Allow()
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAllowSendWithNoLimiterInContext(t *testing.T) {
+	ctx := context.Background()
+	if !allowEmailSend(ctx) {
+		t.Error("allowEmailSend with no limiter in context should allow the send")
+	}
+	if !allowSmsSend(ctx) {
+		t.Error("allowSmsSend with no limiter in context should allow the send")
+	}
+}
+
+func TestAllowSendConsumesSharedLimiter(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	ctx := context.Background()
+	emailCtx := context.WithValue(ctx, emailRateLimitContextKey{}, limiter)
+	smsCtx := context.WithValue(ctx, smsRateLimitContextKey{}, limiter)
+
+	if !allowEmailSend(emailCtx) {
+		t.Fatal("expected the first send to be allowed")
+	}
+	if allowEmailSend(emailCtx) {
+		t.Error("expected the limiter's single token to already be spent")
+	}
+	if allowSmsSend(smsCtx) {
+		t.Error("allowSmsSend should observe the same exhausted limiter")
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// defaultTokenCleanupInterval is how often StartTokenCleanup sweeps expired
+// tokens out of the unified token store when the caller doesn't override it.
+const defaultTokenCleanupInterval = time.Hour
+
+// StartTokenCleanup runs tokenStore.PurgeExpired on a ticker until ctx is
+// done, so expired confirmation/recovery/invite/email-change tokens don't
+// accumulate forever. Meant to be launched once, as a background goroutine,
+// when the API server starts up.
+func (a *API) StartTokenCleanup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultTokenCleanupInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := tokenStore.PurgeExpired(a.db.WithContext(ctx)); err != nil {
+					log.Printf("error purging expired tokens: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"context"
+)
+
+// getExternalURL returns the external URL GoTrue should use to build links
+// back to itself (e.g. in emailed confirmation/recovery links) for the
+// given request context. If domainAllowListHandler matched the incoming
+// Host against a configured custom domain, that domain's URL is used
+// instead of the default API.ExternalURL.
+func (a *API) getExternalURL(ctx context.Context) string {
+	if matched, ok := ctx.Value(externalURLContextKey{}).(string); ok {
+		return matched
+	}
+	return a.config.API.ExternalURL
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/netlify/gotrue/models"
+	"github.com/netlify/gotrue/storage"
+	"github.com/pkg/errors"
+)
+
+// VerifyParams are the parameters accepted by Verify, either as a POST body
+// or (for email link clicks) as query parameters.
+type VerifyParams struct {
+	Type       string `json:"type"`
+	Token      string `json:"token"`
+	TokenHash  string `json:"token_hash"`
+	Email      string `json:"email"`
+	RedirectTo string `json:"redirect_to"`
+}
+
+// legacyTokenColumns maps a TokenType to the hashed column on users that
+// held it before the unified token store existed, so a token minted before
+// the migration can still be redeemed.
+var legacyTokenColumns = map[models.TokenType]string{
+	models.ConfirmationToken:       "confirmation_token",
+	models.InviteToken:             "confirmation_token",
+	models.RecoveryToken:           "recovery_token",
+	models.ReauthenticationToken:   "reauthentication_token",
+	models.EmailChangeCurrentToken: "email_change_token_current",
+	models.EmailChangeNewToken:     "email_change_token_new",
+}
+
+// legacyTokenSentAt returns the *SentAt column that was stamped alongside
+// tokenType's legacy column, so findUserByLegacyToken can apply the same
+// TokenTTL the unified token store enforces. Without this, a token whose
+// store row has been deleted by StartTokenCleanup's purge - which only
+// happens once the row is already expired - would otherwise be accepted
+// forever via the still-set, never-expiring legacy column.
+func legacyTokenSentAt(user *models.User, tokenType models.TokenType) *time.Time {
+	switch tokenType {
+	case models.ConfirmationToken, models.InviteToken:
+		return user.ConfirmationSentAt
+	case models.RecoveryToken:
+		return user.RecoverySentAt
+	case models.ReauthenticationToken:
+		return user.ReauthenticationSentAt
+	case models.EmailChangeCurrentToken, models.EmailChangeNewToken:
+		return user.EmailChangeSentAt
+	}
+	return nil
+}
+
+// verificationTokenType maps the verification_type values accepted by
+// GenerateLink and the send* helpers onto the corresponding TokenType.
+func verificationTokenType(verificationType string) (models.TokenType, bool) {
+	switch verificationType {
+	case signupVerification:
+		return models.ConfirmationToken, true
+	case inviteVerification:
+		return models.InviteToken, true
+	case recoveryVerification, magicLinkVerification:
+		return models.RecoveryToken, true
+	case "reauthentication":
+		return models.ReauthenticationToken, true
+	case "email_change_current":
+		return models.EmailChangeCurrentToken, true
+	case "email_change_new":
+		return models.EmailChangeNewToken, true
+	}
+	return "", false
+}
+
+// Verify redeems a confirmation/recovery/invite/reauthentication/email-change
+// token: it looks the token up in the unified token store, falling back to
+// the legacy hashed column on users for tokens issued before that store
+// existed, then consumes it and applies the corresponding user state change.
+func (a *API) Verify(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	params := &VerifyParams{}
+	if r.Method == http.MethodPost {
+		body, err := getBodyBytes(r)
+		if err != nil {
+			return badRequestError("Could not read body").WithInternalError(err)
+		}
+		if err := json.Unmarshal(body, params); err != nil {
+			return badRequestError("Could not parse JSON: %v", err)
+		}
+	} else {
+		query := r.URL.Query()
+		params.Type = query.Get("type")
+		params.Token = query.Get("token")
+		params.TokenHash = query.Get("token_hash")
+		params.Email = query.Get("email")
+		params.RedirectTo = query.Get("redirect_to")
+	}
+
+	if params.RedirectTo != "" && !isRedirectToAllowed(params.RedirectTo) {
+		return unprocessableEntityError("Invalid redirect_to: host is not on the domain allow list")
+	}
+
+	tokenType, ok := verificationTokenType(params.Type)
+	if !ok {
+		return badRequestError("Invalid verification type: %v", params.Type)
+	}
+
+	tokenHash := params.TokenHash
+	if tokenHash == "" {
+		if params.Token == "" || params.Email == "" {
+			return badRequestError("token_hash, or token and email, are required")
+		}
+		tokenHash = fmt.Sprintf("%x", sha256.Sum224([]byte(params.Email+params.Token)))
+	}
+
+	var user *models.User
+	err := db.Transaction(func(tx *storage.Connection) error {
+		token, terr := tokenStore.FindByHashAndType(tx, tokenHash, tokenType)
+		switch {
+		case terr == nil:
+			if token.IsExpired() {
+				return unprocessableEntityError("Token has expired or is invalid")
+			}
+			user, terr = models.FindUserByID(tx, token.UserID)
+			if terr != nil {
+				return internalServerError("Database error finding user").WithInternalError(terr)
+			}
+			if terr := tokenStore.Consume(tx, token); terr != nil {
+				return terr
+			}
+		case models.IsNotFoundError(terr):
+			user, terr = findUserByLegacyToken(tx, tokenType, tokenHash, a.config.Mailer.TokenTTL)
+			if terr != nil {
+				return terr
+			}
+		default:
+			return internalServerError("Database error finding token").WithInternalError(terr)
+		}
+
+		return applyVerification(tx, user, tokenType, a.config.Mailer.SecureEmailChangeEnabled)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, user)
+}
+
+// findUserByLegacyToken looks a user up by the pre-token-store hashed
+// column tokenType used to live in, for tokens minted before the migration,
+// enforcing the same tokenTTL the unified store would have expired it
+// under so a stale legacy column can't outlive its purged store row.
+func findUserByLegacyToken(tx *storage.Connection, tokenType models.TokenType, tokenHash string, tokenTTL time.Duration) (*models.User, error) {
+	column, ok := legacyTokenColumns[tokenType]
+	if !ok {
+		return nil, notFoundError("Token not found")
+	}
+	user := &models.User{}
+	if err := tx.Q().Where(column+" = ?", tokenHash).First(user); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, notFoundError("Token not found")
+		}
+		return nil, internalServerError("Database error finding user").WithInternalError(err)
+	}
+	sentAt := legacyTokenSentAt(user, tokenType)
+	if sentAt == nil || sentAt.Add(tokenTTL).Before(time.Now()) {
+		return nil, unprocessableEntityError("Token has expired or is invalid")
+	}
+	return user, nil
+}
+
+// applyVerification transitions user's state for a successfully consumed
+// token of tokenType. secureEmailChangeEnabled mirrors
+// config.Mailer.SecureEmailChangeEnabled: when it's on, both the current and
+// new address must confirm their leg before the email actually changes;
+// when it's off, only the new address ever gets a token, and confirming it
+// completes the change immediately.
+func applyVerification(tx *storage.Connection, user *models.User, tokenType models.TokenType, secureEmailChangeEnabled bool) error {
+	switch tokenType {
+	case models.ConfirmationToken, models.InviteToken:
+		if user.IsConfirmed() {
+			return nil
+		}
+		now := time.Now()
+		user.ConfirmedAt = &now
+		user.ConfirmationToken = ""
+		return errors.Wrap(tx.UpdateOnly(user, "confirmed_at", "confirmation_token"), "Database error confirming user")
+	case models.RecoveryToken:
+		user.RecoveryToken = ""
+		if !user.IsConfirmed() {
+			now := time.Now()
+			user.ConfirmedAt = &now
+			return errors.Wrap(tx.UpdateOnly(user, "confirmed_at", "recovery_token"), "Database error confirming user")
+		}
+		return errors.Wrap(tx.UpdateOnly(user, "recovery_token"), "Database error updating user for recovery")
+	case models.ReauthenticationToken:
+		user.ReauthenticationToken = ""
+		return errors.Wrap(tx.UpdateOnly(user, "reauthentication_token"), "Database error updating user for reauthentication")
+	case models.EmailChangeCurrentToken:
+		user.EmailChangeTokenCurrent = ""
+		return applyEmailChangeLeg(tx, user, "email_change_token_current", secureEmailChangeEnabled)
+	case models.EmailChangeNewToken:
+		user.EmailChangeTokenNew = ""
+		return applyEmailChangeLeg(tx, user, "email_change_token_new", secureEmailChangeEnabled)
+	}
+	return nil
+}
+
+// applyEmailChangeLeg records that one leg of an email change - the
+// current or new address, whichever token column the caller just cleared
+// on user - has been confirmed. With secure email change off, a single leg
+// (the new address) always completes the change. With it on, the change
+// only completes once both legs have confirmed, tracked via
+// EmailChangeConfirmStatus.
+func applyEmailChangeLeg(tx *storage.Connection, user *models.User, tokenColumn string, secureEmailChangeEnabled bool) error {
+	if !secureEmailChangeEnabled {
+		return completeEmailChange(tx, user)
+	}
+
+	user.EmailChangeConfirmStatus++
+	if user.EmailChangeConfirmStatus >= doubleConfirmation {
+		return completeEmailChange(tx, user)
+	}
+	return errors.Wrap(tx.UpdateOnly(user, tokenColumn, "email_change_confirm_status"), "Database error updating user for email change")
+}
+
+// completeEmailChange swaps the user's primary email over from EmailChange
+// and resets both legs' state, once whichever confirmation policy applies
+// has been satisfied.
+func completeEmailChange(tx *storage.Connection, user *models.User) error {
+	user.Email = user.EmailChange
+	user.EmailChange = ""
+	user.EmailChangeTokenCurrent = ""
+	user.EmailChangeTokenNew = ""
+	user.EmailChangeConfirmStatus = zeroConfirmation
+	return errors.Wrap(tx.UpdateOnly(user, "email", "email_change", "email_change_token_current", "email_change_token_new", "email_change_confirm_status"), "Database error updating user for email change")
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netlify/gotrue/models"
+)
+
+func TestVerificationTokenType(t *testing.T) {
+	cases := []struct {
+		in   string
+		want models.TokenType
+	}{
+		{signupVerification, models.ConfirmationToken},
+		{inviteVerification, models.InviteToken},
+		{recoveryVerification, models.RecoveryToken},
+		{magicLinkVerification, models.RecoveryToken},
+		{"reauthentication", models.ReauthenticationToken},
+		{"email_change_current", models.EmailChangeCurrentToken},
+		{"email_change_new", models.EmailChangeNewToken},
+	}
+	for _, c := range cases {
+		got, ok := verificationTokenType(c.in)
+		if !ok || got != c.want {
+			t.Errorf("verificationTokenType(%q) = (%q, %v), want (%q, true)", c.in, got, ok, c.want)
+		}
+	}
+
+	if _, ok := verificationTokenType("not-a-real-type"); ok {
+		t.Error("expected an unrecognized verification type to be rejected")
+	}
+}
+
+func TestLegacyTokenSentAt(t *testing.T) {
+	confirmationSentAt := time.Now().Add(-time.Minute)
+	recoverySentAt := time.Now().Add(-2 * time.Minute)
+	reauthSentAt := time.Now().Add(-3 * time.Minute)
+	emailChangeSentAt := time.Now().Add(-4 * time.Minute)
+	user := &models.User{
+		ConfirmationSentAt:     &confirmationSentAt,
+		RecoverySentAt:         &recoverySentAt,
+		ReauthenticationSentAt: &reauthSentAt,
+		EmailChangeSentAt:      &emailChangeSentAt,
+	}
+
+	cases := []struct {
+		tokenType models.TokenType
+		want      *time.Time
+	}{
+		{models.ConfirmationToken, &confirmationSentAt},
+		{models.InviteToken, &confirmationSentAt},
+		{models.RecoveryToken, &recoverySentAt},
+		{models.ReauthenticationToken, &reauthSentAt},
+		{models.EmailChangeCurrentToken, &emailChangeSentAt},
+		{models.EmailChangeNewToken, &emailChangeSentAt},
+	}
+	for _, c := range cases {
+		got := legacyTokenSentAt(user, c.tokenType)
+		if got != c.want {
+			t.Errorf("legacyTokenSentAt(_, %q) = %v, want %v", c.tokenType, got, c.want)
+		}
+	}
+
+	if got := legacyTokenSentAt(&models.User{}, models.TokenType("bogus")); got != nil {
+		t.Errorf("legacyTokenSentAt for an unrecognized token type = %v, want nil", got)
+	}
+}
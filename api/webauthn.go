@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid"
+	"github.com/netlify/gotrue/models"
+	"github.com/netlify/gotrue/storage"
+	"github.com/pkg/errors"
+)
+
+// EnrollWebAuthnFactorParams are the parameters for beginning WebAuthn
+// registration.
+type EnrollWebAuthnFactorParams struct {
+	FriendlyName string `json:"friendly_name"`
+}
+
+// EnrollWebAuthnFactorResponse carries the credential creation options the
+// client must pass to navigator.credentials.create(), alongside the
+// challenge id VerifyWebAuthnEnrollment needs to replay the ceremony.
+type EnrollWebAuthnFactorResponse struct {
+	ChallengeID uuid.UUID      `json:"challenge_id"`
+	Factor      *models.Factor `json:"factor"`
+	Options     interface{}    `json:"options"`
+}
+
+// VerifyWebAuthnEnrollmentParams are the parameters for completing WebAuthn
+// registration.
+type VerifyWebAuthnEnrollmentParams struct {
+	ChallengeID uuid.UUID `json:"challenge_id"`
+}
+
+// EnrollWebAuthnFactor begins the WebAuthn registration ceremony for the
+// user. It creates the unverified Factor up front (the same way TOTP
+// enrollment does) so the issued Challenge has a factor_id to attach the
+// ceremony's SessionData to.
+func (a *API) EnrollWebAuthnFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	params := &EnrollWebAuthnFactorParams{}
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return badRequestError("Could not read body").WithInternalError(err)
+	}
+	if err := json.Unmarshal(body, params); err != nil {
+		return badRequestError("Could not parse JSON: %v", err)
+	}
+
+	existingFactors, err := models.FindFactorsByUser(a.db, user)
+	if err != nil {
+		return internalServerError("Database error finding factors").WithInternalError(err)
+	}
+
+	wa, err := a.newWebAuthn()
+	if err != nil {
+		return internalServerError("Error configuring WebAuthn").WithInternalError(err)
+	}
+
+	options, sessionData, err := wa.BeginRegistration(newWebAuthnUser(user, existingFactors))
+	if err != nil {
+		return internalServerError("Error beginning WebAuthn registration").WithInternalError(err)
+	}
+	sessionDataJSON, err := json.Marshal(sessionData)
+	if err != nil {
+		return internalServerError("Error marshalling WebAuthn session data").WithInternalError(err)
+	}
+
+	var factor *models.Factor
+	var challenge *models.Challenge
+	err = db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		factor, terr = models.NewWebAuthnFactor(user, params.FriendlyName, "", nil, "", "")
+		if terr != nil {
+			return terr
+		}
+		if terr := tx.Create(factor); terr != nil {
+			return terr
+		}
+		challenge, terr = factor.CreateChallenge(tx, r.RemoteAddr, a.config.MFA.ChallengeExpiryDuration)
+		if terr != nil {
+			return terr
+		}
+		return challenge.SetWebAuthnSessionData(tx, sessionDataJSON)
+	})
+	if err != nil {
+		return internalServerError("Database error enrolling WebAuthn factor").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, EnrollWebAuthnFactorResponse{
+		ChallengeID: challenge.ID,
+		Factor:      factor,
+		Options:     options,
+	})
+}
+
+// VerifyWebAuthnEnrollment completes the WebAuthn registration ceremony,
+// verifying the attestation against the SessionData issued at enrollment
+// and marking the Factor created then as verified.
+func (a *API) VerifyWebAuthnEnrollment(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	factor, err := a.loadFactor(r)
+	if err != nil {
+		return err
+	}
+	if factor.FactorType != models.WebAuthn {
+		return badRequestError("Factor is not a WebAuthn factor")
+	}
+
+	params := &VerifyWebAuthnEnrollmentParams{}
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return badRequestError("Could not read body").WithInternalError(err)
+	}
+	if err := json.Unmarshal(body, params); err != nil {
+		return badRequestError("Could not parse JSON: %v", err)
+	}
+
+	challenge, err := models.FindChallengeByID(db, params.ChallengeID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("Challenge not found")
+		}
+		return internalServerError("Database error finding challenge").WithInternalError(err)
+	}
+	if challenge.FactorID != factor.ID {
+		return notFoundError("Challenge not found")
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(challenge.WebAuthnSessionData, &sessionData); err != nil {
+		return internalServerError("Error reading WebAuthn session data").WithInternalError(err)
+	}
+
+	wa, err := a.newWebAuthn()
+	if err != nil {
+		return internalServerError("Error configuring WebAuthn").WithInternalError(err)
+	}
+
+	credential, err := wa.FinishRegistration(newWebAuthnUser(user, nil), sessionData, r)
+	if err != nil {
+		return unprocessableEntityError("Error completing WebAuthn registration: %v", err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		factor.WebAuthnCredentialID = base64.RawURLEncoding.EncodeToString(credential.ID)
+		factor.WebAuthnPublicKey = credential.PublicKey
+		factor.WebAuthnAAGUID = credential.Authenticator.AAGUID.String()
+		factor.WebAuthnSignCount = uint32(credential.Authenticator.SignCount)
+		if terr := tx.UpdateOnly(factor, "webauthn_credential_id", "webauthn_public_key", "webauthn_aaguid", "webauthn_sign_count", "updated_at"); terr != nil {
+			return terr
+		}
+		if terr := factor.UpdateStatus(tx, models.FactorStateVerified); terr != nil {
+			return terr
+		}
+		return challenge.Verify(tx)
+	})
+	if err != nil {
+		return internalServerError("Database error completing WebAuthn factor").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, factor)
+}
+
+// newWebAuthn builds the webauthn.WebAuthn relying-party config from the
+// site's external URL.
+func (a *API) newWebAuthn() (*webauthn.WebAuthn, error) {
+	externalURL := a.config.API.ExternalURL
+	rpID, err := rpIDFromExternalURL(externalURL)
+	if err != nil {
+		return nil, err
+	}
+	return webauthn.New(&webauthn.Config{
+		RPDisplayName: "GoTrue",
+		RPID:          rpID,
+		RPOrigins:     []string{externalURL},
+	})
+}
+
+// rpIDFromExternalURL derives a WebAuthn RPID - a bare effective domain,
+// with no scheme, port, or path - from externalURL. webauthn.New rejects
+// anything else.
+func rpIDFromExternalURL(externalURL string) (string, error) {
+	u, err := url.Parse(externalURL)
+	if err != nil {
+		return "", errors.Wrap(err, "Error parsing API external URL as a WebAuthn RPID")
+	}
+	if u.Hostname() == "" {
+		return "", errors.Errorf("API external URL %q has no host to use as a WebAuthn RPID", externalURL)
+	}
+	return u.Hostname(), nil
+}
+
+// webAuthnUser adapts models.User to webauthn.User, supplying the
+// credentials of any already-enrolled WebAuthn factors so the library can
+// restrict the allowed credentials at login time. BeginRegistration is
+// called without an exclusion list, so these credentials are not excluded
+// at registration time.
+type webAuthnUser struct {
+	*models.User
+	credentials []webauthn.Credential
+}
+
+// newWebAuthnUser builds a webAuthnUser carrying the WebAuthn credentials of
+// factors, ignoring any factor that isn't a verified WebAuthn credential.
+func newWebAuthnUser(user *models.User, factors []*models.Factor) webAuthnUser {
+	credentials := make([]webauthn.Credential, 0, len(factors))
+	for _, f := range factors {
+		cred, ok := factorWebAuthnCredential(f)
+		if ok {
+			credentials = append(credentials, cred)
+		}
+	}
+	return webAuthnUser{User: user, credentials: credentials}
+}
+
+// factorWebAuthnCredential converts a verified WebAuthn Factor into the
+// webauthn.Credential the go-webauthn library needs to look up an allowed
+// credential or verify an assertion's signature counter against.
+func factorWebAuthnCredential(f *models.Factor) (webauthn.Credential, bool) {
+	if f.FactorType != models.WebAuthn || f.WebAuthnCredentialID == "" {
+		return webauthn.Credential{}, false
+	}
+	id, err := base64.RawURLEncoding.DecodeString(f.WebAuthnCredentialID)
+	if err != nil {
+		return webauthn.Credential{}, false
+	}
+	return webauthn.Credential{
+		ID:        id,
+		PublicKey: f.WebAuthnPublicKey,
+		Authenticator: webauthn.Authenticator{
+			SignCount: f.WebAuthnSignCount,
+		},
+	}, true
+}
+
+func (u webAuthnUser) WebAuthnID() []byte                         { return []byte(u.ID.String()) }
+func (u webAuthnUser) WebAuthnName() string                       { return u.GetEmail() }
+func (u webAuthnUser) WebAuthnDisplayName() string                { return u.GetEmail() }
+func (u webAuthnUser) WebAuthnIcon() string                       { return "" }
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid"
+	"github.com/netlify/gotrue/models"
+	"github.com/netlify/gotrue/storage"
+)
+
+// FinishWebAuthnAssertionParams are the parameters for completing the
+// WebAuthn sign-in ceremony.
+type FinishWebAuthnAssertionParams struct {
+	ChallengeID uuid.UUID `json:"challenge_id"`
+}
+
+// BeginWebAuthnAssertion starts the WebAuthn sign-in ceremony for a
+// previously enrolled factor, returning the credential request options the
+// client must pass to navigator.credentials.get(). The resulting
+// SessionData is persisted on the issued models.Challenge so
+// FinishWebAuthnAssertion can replay it against the ceremony's response.
+func (a *API) BeginWebAuthnAssertion(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	factor, err := a.loadFactor(r)
+	if err != nil {
+		return err
+	}
+	if factor.FactorType != models.WebAuthn {
+		return badRequestError("Factor is not a WebAuthn factor")
+	}
+
+	wa, err := a.newWebAuthn()
+	if err != nil {
+		return internalServerError("Error configuring WebAuthn").WithInternalError(err)
+	}
+
+	options, sessionData, err := wa.BeginLogin(newWebAuthnUser(&factor.User, []*models.Factor{factor}))
+	if err != nil {
+		return internalServerError("Error beginning WebAuthn assertion").WithInternalError(err)
+	}
+	sessionDataJSON, err := json.Marshal(sessionData)
+	if err != nil {
+		return internalServerError("Error marshalling WebAuthn session data").WithInternalError(err)
+	}
+
+	var challenge *models.Challenge
+	err = db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		challenge, terr = factor.CreateChallenge(tx, r.RemoteAddr, a.config.MFA.ChallengeExpiryDuration)
+		if terr != nil {
+			return terr
+		}
+		return challenge.SetWebAuthnSessionData(tx, sessionDataJSON)
+	})
+	if err != nil {
+		return internalServerError("Database error creating challenge").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, struct {
+		ChallengeID string      `json:"challenge_id"`
+		Options     interface{} `json:"options"`
+	}{
+		ChallengeID: challenge.ID.String(),
+		Options:     options,
+	})
+}
+
+// FinishWebAuthnAssertion completes the WebAuthn sign-in ceremony, verifying
+// the assertion against the SessionData issued for the challenge, bumping
+// the credential's signature counter, and recording a "webauthn" AMR claim
+// on success.
+func (a *API) FinishWebAuthnAssertion(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	factor, err := a.loadFactor(r)
+	if err != nil {
+		return err
+	}
+	if factor.FactorType != models.WebAuthn {
+		return badRequestError("Factor is not a WebAuthn factor")
+	}
+
+	params := &FinishWebAuthnAssertionParams{}
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return badRequestError("Could not read body").WithInternalError(err)
+	}
+	if err := json.Unmarshal(body, params); err != nil {
+		return badRequestError("Could not parse JSON: %v", err)
+	}
+
+	challenge, err := models.FindChallengeByID(db, params.ChallengeID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("Challenge not found")
+		}
+		return internalServerError("Database error finding challenge").WithInternalError(err)
+	}
+	if challenge.FactorID != factor.ID {
+		return notFoundError("Challenge not found")
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(challenge.WebAuthnSessionData, &sessionData); err != nil {
+		return internalServerError("Error reading WebAuthn session data").WithInternalError(err)
+	}
+
+	wa, err := a.newWebAuthn()
+	if err != nil {
+		return internalServerError("Error configuring WebAuthn").WithInternalError(err)
+	}
+
+	credential, err := wa.FinishLogin(newWebAuthnUser(&factor.User, []*models.Factor{factor}), sessionData, r)
+	if err != nil {
+		return unprocessableEntityError("Error completing WebAuthn assertion: %v", err)
+	}
+
+	// The factor was resolved from the URL's factor_id, so cross-check the
+	// credential the authenticator actually asserted really belongs to it -
+	// this is also the lookup FindFactorByCredentialID exists for, and would
+	// be the sole resolution path for a future username-less sign-in flow.
+	assertedFactor, err := models.FindFactorByCredentialID(db, base64.RawURLEncoding.EncodeToString(credential.ID))
+	if err != nil {
+		return internalServerError("Database error resolving asserted credential").WithInternalError(err)
+	}
+	if assertedFactor.ID != factor.ID {
+		return unprocessableEntityError("Asserted credential does not match the requested factor")
+	}
+
+	session := getSession(ctx)
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := factor.UpdateWebAuthnSignCount(tx, uint32(credential.Authenticator.SignCount)); terr != nil {
+			return terr
+		}
+		if terr := challenge.Verify(tx); terr != nil {
+			return terr
+		}
+		if session != nil {
+			if terr := models.AddClaimToSession(tx, session, models.WebAuthnSignIn); terr != nil {
+				return terr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return internalServerError("Database error updating WebAuthn factor").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, factor)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/netlify/gotrue/models"
+)
+
+func TestRpIDFromExternalURL(t *testing.T) {
+	cases := []struct {
+		externalURL string
+		want        string
+		wantErr     bool
+	}{
+		{externalURL: "https://auth.example.com", want: "auth.example.com"},
+		{externalURL: "https://auth.example.com:8443/some/path", want: "auth.example.com"},
+		{externalURL: "http://localhost:9999", want: "localhost"},
+		{externalURL: "", wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := rpIDFromExternalURL(c.externalURL)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("rpIDFromExternalURL(%q): expected an error, got none", c.externalURL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("rpIDFromExternalURL(%q): unexpected error: %v", c.externalURL, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("rpIDFromExternalURL(%q) = %q, want %q", c.externalURL, got, c.want)
+		}
+	}
+}
+
+func TestFactorWebAuthnCredential(t *testing.T) {
+	totpFactor := &models.Factor{FactorType: models.TOTP}
+	if _, ok := factorWebAuthnCredential(totpFactor); ok {
+		t.Error("a TOTP factor should not produce a WebAuthn credential")
+	}
+
+	unenrolled := &models.Factor{FactorType: models.WebAuthn}
+	if _, ok := factorWebAuthnCredential(unenrolled); ok {
+		t.Error("a WebAuthn factor with no credential id should not produce a credential")
+	}
+
+	rawID := []byte{1, 2, 3, 4}
+	webAuthnFactor := &models.Factor{
+		FactorType:           models.WebAuthn,
+		WebAuthnCredentialID: base64.RawURLEncoding.EncodeToString(rawID),
+		WebAuthnPublicKey:    []byte{5, 6, 7},
+		WebAuthnSignCount:    42,
+	}
+	cred, ok := factorWebAuthnCredential(webAuthnFactor)
+	if !ok {
+		t.Fatal("expected a credential for an enrolled WebAuthn factor")
+	}
+	if string(cred.ID) != string(rawID) {
+		t.Errorf("cred.ID = %v, want %v", cred.ID, rawID)
+	}
+	if cred.Authenticator.SignCount != 42 {
+		t.Errorf("cred.Authenticator.SignCount = %d, want 42", cred.Authenticator.SignCount)
+	}
+}
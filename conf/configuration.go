@@ -0,0 +1,41 @@
+package conf
+
+import "time"
+
+// MailerConfiguration holds the settings for the default SMTP/GoMail based
+// mailer, shared by every send* helper in api/mail.go.
+type MailerConfiguration struct {
+	OtpLength                int           `json:"otp_length" split_words:"true" default:"6"`
+	SecureEmailChangeEnabled bool          `json:"secure_email_change_enabled" split_words:"true" default:"true"`
+	MaxFrequency             time.Duration `json:"max_frequency" split_words:"true" default:"1m"`
+	TokenTTL                 time.Duration `json:"token_ttl" split_words:"true" default:"24h"`
+}
+
+// APIConfiguration holds settings for the HTTP API server itself.
+type APIConfiguration struct {
+	ExternalURL string `json:"external_url" split_words:"true"`
+}
+
+// MFAConfiguration holds settings for multi-factor authentication.
+type MFAConfiguration struct {
+	ChallengeExpiryDuration time.Duration `json:"challenge_expiry_duration" split_words:"true" default:"5m"`
+}
+
+// GlobalConfiguration is the root configuration object GoTrue is started
+// with, built from environment variables and/or a config file.
+type GlobalConfiguration struct {
+	SiteURL            string              `json:"site_url" split_words:"true"`
+	PasswordMinLength  int                 `json:"password_min_length" split_words:"true" default:"6"`
+	API                APIConfiguration    `json:"api"`
+	Mailer             MailerConfiguration `json:"mailer"`
+	Hook               HookConfiguration   `json:"hook"`
+	MFA                MFAConfiguration    `json:"mfa"`
+	RateLimitEmailSent int                 `json:"rate_limit_email_sent" split_words:"true" default:"30"`
+	RateLimitSmsSent   int                 `json:"rate_limit_sms_sent" split_words:"true" default:"30"`
+
+	// DomainAllowList is a comma-separated list of hostnames GoTrue is
+	// permitted to use as the external URL for a request, for deployments
+	// fronted by more than one custom domain. Empty means only
+	// API.ExternalURL is ever used.
+	DomainAllowList string `json:"domain_allow_list" split_words:"true"`
+}
@@ -0,0 +1,45 @@
+package conf
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DomainAllowList parses GlobalConfiguration.DomainAllowList into a lookup
+// from hostname to the external URL GoTrue should use when a request comes
+// in for that host.
+type DomainAllowList map[string]*url.URL
+
+// ParseDomainAllowList parses the comma-separated DomainAllowList setting,
+// building one *url.URL per entry. Each entry may be a bare hostname (in
+// which case it's treated as an https:// external URL) or a full URL.
+func (c *GlobalConfiguration) ParseDomainAllowList() (DomainAllowList, error) {
+	allowList := DomainAllowList{}
+	if c.DomainAllowList == "" {
+		return allowList, nil
+	}
+
+	for _, entry := range strings.Split(c.DomainAllowList, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		raw := entry
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return nil, newValidationError("domain_allow_list", "invalid entry \""+entry+"\": "+err.Error())
+		}
+		allowList[parsed.Hostname()] = parsed
+	}
+	return allowList, nil
+}
+
+// Match returns the external URL configured for hostname, and whether one
+// was found.
+func (l DomainAllowList) Match(hostname string) (*url.URL, bool) {
+	u, ok := l[hostname]
+	return u, ok
+}
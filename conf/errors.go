@@ -0,0 +1,19 @@
+package conf
+
+import "fmt"
+
+// ValidationError is returned when a configuration value fails validation
+// at startup, so the operator gets a precise field name instead of a
+// generic parse failure.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("conf: %s: %s", e.Field, e.Reason)
+}
+
+func newValidationError(field, reason string) error {
+	return &ValidationError{Field: field, Reason: reason}
+}
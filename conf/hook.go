@@ -0,0 +1,55 @@
+package conf
+
+import "time"
+
+// HookConfiguration groups the configuration for the extensibility points
+// ("hooks") that let an operator replace a piece of GoTrue's default
+// behaviour with their own HTTP or pg-function backed implementation.
+type HookConfiguration struct {
+	SendEmail SendEmailHookConfiguration `json:"send_email" split_words:"true"`
+}
+
+// SendEmailHookConfiguration configures the custom send-email hook. When
+// Enabled is true, api.CustomMailer is used in place of the default
+// GoMail-based mailer for every outgoing transactional email.
+type SendEmailHookConfiguration struct {
+	Enabled bool `json:"enabled" split_words:"true"`
+
+	// URI identifies the hook target. For the "http" transport this is the
+	// webhook endpoint; for the "pg-functions" transport this is of the
+	// form "pg-functions://<schema>/<function_name>".
+	URI string `json:"uri" split_words:"true"`
+
+	// Transport selects how the hook is invoked: "http" or "pg-functions".
+	Transport string `json:"transport" split_words:"true" default:"http"`
+
+	// Secret is used to HMAC-sign the payload delivered to the hook, so the
+	// receiving end can verify the request originated from this GoTrue
+	// instance. It is unused for the "pg-functions" transport.
+	Secret string `json:"secret" split_words:"true"`
+
+	// Timeout bounds how long GoTrue waits for the hook to respond.
+	Timeout time.Duration `json:"timeout" split_words:"true" default:"5s"`
+
+	// MaxRetries bounds how many times a failed hook call is retried before
+	// the send is reported as failed to the caller.
+	MaxRetries int `json:"max_retries" split_words:"true" default:"0"`
+}
+
+func (c *SendEmailHookConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.URI == "" {
+		return newValidationError("hook.send_email.uri", "a uri is required when the send email hook is enabled")
+	}
+	switch c.Transport {
+	case "http", "pg-functions":
+	default:
+		return newValidationError("hook.send_email.transport", "transport must be one of \"http\" or \"pg-functions\"")
+	}
+	if c.Transport == "http" && c.Secret == "" {
+		return newValidationError("hook.send_email.secret", "a secret is required to sign http hook requests")
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const otpDigits = "0123456789"
+
+// GenerateOtp returns a random numeric one-time password of the given
+// length, suitable for emailing/texting to a user as a short-lived code.
+func GenerateOtp(length int) (string, error) {
+	otp := make([]byte, length)
+	for i := range otp {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(otpDigits))))
+		if err != nil {
+			return "", err
+		}
+		otp[i] = otpDigits[n.Int64()]
+	}
+	return string(otp), nil
+}
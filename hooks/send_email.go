@@ -0,0 +1,157 @@
+// Package hooks implements the pluggable webhook/pg-function extension
+// points that let an operator run custom code in place of a GoTrue default,
+// starting with the send-email hook.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/netlify/gotrue/conf"
+	"github.com/netlify/gotrue/storage"
+	"github.com/pkg/errors"
+)
+
+// EmailData carries the per-action data a send-email hook needs to render
+// and link back to the flow that triggered it.
+type EmailData struct {
+	Token           string `json:"token"`
+	TokenHash       string `json:"token_hash"`
+	TokenNew        string `json:"token_new,omitempty"`
+	TokenHashNew    string `json:"token_hash_new,omitempty"`
+	RedirectTo      string `json:"redirect_to"`
+	EmailActionType string `json:"email_action_type"`
+	SiteURL         string `json:"site_url"`
+}
+
+// SendEmailInput is the payload delivered to the send-email hook.
+type SendEmailInput struct {
+	User      interface{} `json:"user"`
+	EmailData EmailData   `json:"email_data"`
+}
+
+// SendEmailOutput is the JSON object expected back from the hook, which
+// currently carries nothing but an optional "error" field. A non-2xx status,
+// a body that is not a JSON object (an empty body included), or a non-empty
+// "error" field fails the send.
+type SendEmailOutput struct {
+	Error string `json:"error,omitempty"`
+}
+
+// SendEmailDispatcher invokes the configured send-email hook instead of the
+// default mailer transport.
+type SendEmailDispatcher struct {
+	config *conf.SendEmailHookConfiguration
+	db     *storage.Connection
+	client *http.Client
+}
+
+func NewSendEmailDispatcher(config *conf.SendEmailHookConfiguration, db *storage.Connection) *SendEmailDispatcher {
+	return &SendEmailDispatcher{
+		config: config,
+		db:     db,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Dispatch runs the hook, retrying up to config.MaxRetries times on error.
+func (d *SendEmailDispatcher) Dispatch(ctx context.Context, input *SendEmailInput) (*SendEmailOutput, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling send email hook payload")
+	}
+
+	var output *SendEmailOutput
+	var lastErr error
+	for attempt := 0; attempt <= d.config.MaxRetries; attempt++ {
+		switch d.config.Transport {
+		case "pg-functions":
+			output, lastErr = d.dispatchPostgres(ctx, payload)
+		default:
+			output, lastErr = d.dispatchHTTP(ctx, payload)
+		}
+		if lastErr == nil {
+			return output, nil
+		}
+	}
+	return nil, errors.Wrap(lastErr, "send email hook failed")
+}
+
+func (d *SendEmailDispatcher) dispatchHTTP(ctx context.Context, payload []byte) (*SendEmailOutput, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.URI, bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating send email hook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating webhook id")
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := signPayload(d.config.Secret, id.String(), timestamp, payload)
+
+	req.Header.Set("Webhook-ID", id.String())
+	req.Header.Set("Webhook-Timestamp", timestamp)
+	req.Header.Set("Webhook-Signature", signature)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling send email hook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("send email hook returned status %d", resp.StatusCode)
+	}
+
+	output := &SendEmailOutput{}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return nil, errors.Wrap(err, "error decoding send email hook response")
+	}
+	if output.Error != "" {
+		return nil, errors.New(output.Error)
+	}
+	return output, nil
+}
+
+// dispatchPostgres runs the hook as a SQL function call inside a short-lived
+// transaction, passing the JSON payload and returning its JSON result.
+func (d *SendEmailDispatcher) dispatchPostgres(ctx context.Context, payload []byte) (*SendEmailOutput, error) {
+	hookName := strings.Replace(strings.TrimPrefix(d.config.URI, "pg-functions://"), "/", ".", 1)
+
+	output := &SendEmailOutput{}
+	err := d.db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		var result []byte
+		if err := tx.RawQuery(fmt.Sprintf("SELECT %s(?)", hookName), string(payload)).First(&result); err != nil {
+			return errors.Wrap(err, "error calling send email hook function")
+		}
+		return json.Unmarshal(result, output)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Error != "" {
+		return nil, errors.New(output.Error)
+	}
+	return output, nil
+}
+
+// signPayload produces the "v1,<base64 hmac>" signature GoTrue expects the
+// hook receiver to verify against Webhook-ID.Webhook-Timestamp.<payload>.
+func signPayload(secret, id, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(payload)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
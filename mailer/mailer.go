@@ -0,0 +1,20 @@
+package mailer
+
+import (
+	"github.com/netlify/gotrue/models"
+)
+
+// Mailer is the interface implemented by anything capable of sending the
+// transactional emails GoTrue issues as part of its auth flows. The default
+// implementation, TemplateMailer, sends nothing; api.CustomMailer sends
+// through a configured webhook instead.
+type Mailer interface {
+	ValidateEmail(email string) error
+	GetEmailActionLink(user *models.User, actionType, referrerURL, externalURL string) (string, error)
+	ConfirmationMail(user *models.User, otp, referrerURL, externalURL string) error
+	InviteMail(user *models.User, otp, referrerURL, externalURL string) error
+	RecoveryMail(user *models.User, otp, referrerURL, externalURL string) error
+	MagicLinkMail(user *models.User, otp, referrerURL, externalURL string) error
+	EmailChangeMail(user *models.User, otpNew, otpCurrent, referrerURL, externalURL string) error
+	ReauthenticateMail(user *models.User, otp string) error
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"github.com/netlify/gotrue/conf"
+	"github.com/netlify/gotrue/models"
+)
+
+// TemplateMailer is the default Mailer implementation. Its send methods
+// return nil without rendering or sending any email, GetEmailActionLink
+// returns externalURL unchanged, and ValidateEmail accepts every address.
+type TemplateMailer struct {
+	config *conf.GlobalConfiguration
+}
+
+func NewMailer(config *conf.GlobalConfiguration) Mailer {
+	return &TemplateMailer{config: config}
+}
+
+func (m *TemplateMailer) ValidateEmail(email string) error {
+	return nil
+}
+
+func (m *TemplateMailer) GetEmailActionLink(user *models.User, actionType, referrerURL, externalURL string) (string, error) {
+	return externalURL, nil
+}
+
+func (m *TemplateMailer) ConfirmationMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return nil
+}
+
+func (m *TemplateMailer) InviteMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return nil
+}
+
+func (m *TemplateMailer) RecoveryMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return nil
+}
+
+func (m *TemplateMailer) MagicLinkMail(user *models.User, otp, referrerURL, externalURL string) error {
+	return nil
+}
+
+func (m *TemplateMailer) EmailChangeMail(user *models.User, otpNew, otpCurrent, referrerURL, externalURL string) error {
+	return nil
+}
+
+func (m *TemplateMailer) ReauthenticateMail(user *models.User, otp string) error {
+	return nil
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/netlify/gotrue/storage"
+	"github.com/pkg/errors"
+)
+
+// MaxChallengeVerifyAttempts bounds how many times a single challenge may
+// be verified against before it must be abandoned and a fresh one issued.
+const MaxChallengeVerifyAttempts = 5
+
+// Challenge represents one attempt at satisfying a Factor's MFA
+// verification. Splitting verification into "create a challenge" then
+// "verify the challenge" gives factor types that need server-generated
+// state between those two steps (e.g. a WebAuthn assertion nonce)
+// somewhere to keep it.
+type Challenge struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	FactorID       uuid.UUID  `json:"factor_id" db:"factor_id"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	VerifiedAt     *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+	IPAddress      string     `json:"ip_address" db:"ip_address"`
+	ExpiresAt      time.Time  `json:"-" db:"expires_at"`
+	VerifyAttempts int        `json:"-" db:"verify_attempts"`
+
+	// WebAuthnSessionData holds the marshaled webauthn.SessionData issued
+	// when this challenge was created, for factor types (currently only
+	// WebAuthn) that need server-generated state echoed back on the finish
+	// step. Empty for factor types that don't need it.
+	WebAuthnSessionData []byte `json:"-" db:"webauthn_session_data"`
+}
+
+func (Challenge) TableName() string {
+	return "mfa_challenges"
+}
+
+// ChallengeNotFoundError is returned when a challenge lookup doesn't match
+// any row.
+type ChallengeNotFoundError struct{}
+
+func (e ChallengeNotFoundError) Error() string {
+	return "Challenge not found"
+}
+
+// ChallengeExpiredError is returned when Verify is called on a challenge
+// past its expiry.
+type ChallengeExpiredError struct{}
+
+func (e ChallengeExpiredError) Error() string {
+	return "Challenge has expired, please issue a new one"
+}
+
+// ChallengeAlreadyVerifiedError is returned when Verify is called on a
+// challenge that was already successfully verified - challenges are
+// single-use.
+type ChallengeAlreadyVerifiedError struct{}
+
+func (e ChallengeAlreadyVerifiedError) Error() string {
+	return "Challenge has already been verified"
+}
+
+// TooManyChallengeAttemptsError is returned once a challenge has been
+// attempted MaxChallengeVerifyAttempts times without success.
+type TooManyChallengeAttemptsError struct{}
+
+func (e TooManyChallengeAttemptsError) Error() string {
+	return "Too many verification attempts, please request a new challenge"
+}
+
+// CreateChallenge issues a new Challenge for the factor, expiring after
+// expiryDuration.
+func (f *Factor) CreateChallenge(tx *storage.Connection, ipAddress string, expiryDuration time.Duration) (*Challenge, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error generating unique id")
+	}
+
+	now := time.Now()
+	challenge := &Challenge{
+		ID:        id,
+		FactorID:  f.ID,
+		CreatedAt: now,
+		IPAddress: ipAddress,
+		ExpiresAt: now.Add(expiryDuration),
+	}
+	if err := tx.Create(challenge); err != nil {
+		return nil, errors.Wrap(err, "Database error creating challenge")
+	}
+	return challenge, nil
+}
+
+// SetWebAuthnSessionData persists the caller-marshaled webauthn.SessionData
+// issued for this challenge, so it can be read back and replayed on the
+// matching finish step.
+func (c *Challenge) SetWebAuthnSessionData(tx *storage.Connection, data []byte) error {
+	c.WebAuthnSessionData = data
+	return errors.Wrap(tx.UpdateOnly(c, "webauthn_session_data"), "Database error updating challenge")
+}
+
+// FindChallengeByID looks up a challenge by its primary key.
+func FindChallengeByID(tx *storage.Connection, id uuid.UUID) (*Challenge, error) {
+	challenge := &Challenge{}
+	if err := tx.Q().Where("id = ?", id).First(challenge); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ChallengeNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "Database error finding challenge")
+	}
+	return challenge, nil
+}
+
+// IsExpired reports whether the challenge has passed its expiry time.
+func (c *Challenge) IsExpired() bool {
+	return c.ExpiresAt.Before(time.Now())
+}
+
+// Verify records a successful verification of the challenge. It fails if
+// the challenge has expired, was already verified, or has exceeded its
+// attempt budget - callers should call RecordFailedAttempt on a code
+// mismatch so these budgets actually get enforced.
+func (c *Challenge) Verify(tx *storage.Connection) error {
+	if c.VerifiedAt != nil {
+		return ChallengeAlreadyVerifiedError{}
+	}
+	if c.IsExpired() {
+		return ChallengeExpiredError{}
+	}
+	if c.VerifyAttempts >= MaxChallengeVerifyAttempts {
+		return TooManyChallengeAttemptsError{}
+	}
+
+	now := time.Now()
+	c.VerifiedAt = &now
+	return errors.Wrap(tx.UpdateOnly(c, "verified_at"), "Database error verifying challenge")
+}
+
+// RecordFailedAttempt increments the challenge's attempt counter after a
+// failed verification, returning TooManyChallengeAttemptsError once the
+// budget is exhausted.
+func (c *Challenge) RecordFailedAttempt(tx *storage.Connection) error {
+	c.VerifyAttempts++
+	if err := tx.UpdateOnly(c, "verify_attempts"); err != nil {
+		return errors.Wrap(err, "Database error recording challenge attempt")
+	}
+	if c.VerifyAttempts >= MaxChallengeVerifyAttempts {
+		return TooManyChallengeAttemptsError{}
+	}
+	return nil
+}
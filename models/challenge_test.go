@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChallengeIsExpired(t *testing.T) {
+	future := Challenge{ExpiresAt: time.Now().Add(time.Minute)}
+	if future.IsExpired() {
+		t.Error("challenge expiring in the future should not be expired")
+	}
+
+	past := Challenge{ExpiresAt: time.Now().Add(-time.Minute)}
+	if !past.IsExpired() {
+		t.Error("challenge that expired in the past should be expired")
+	}
+}
+
+func TestMaxChallengeVerifyAttemptsErrors(t *testing.T) {
+	if (TooManyChallengeAttemptsError{}).Error() == "" {
+		t.Error("TooManyChallengeAttemptsError should have a non-empty message")
+	}
+	if (ChallengeExpiredError{}).Error() == "" {
+		t.Error("ChallengeExpiredError should have a non-empty message")
+	}
+	if (ChallengeAlreadyVerifiedError{}).Error() == "" {
+		t.Error("ChallengeAlreadyVerifiedError should have a non-empty message")
+	}
+}
@@ -15,7 +15,10 @@ const (
 	FactorStateVerified   = "verified"
 )
 
-const TOTP = "totp"
+const (
+	TOTP     = "totp"
+	WebAuthn = "webauthn"
+)
 
 type AuthenticationMethod int
 
@@ -24,6 +27,7 @@ const (
 	PasswordGrant
 	OTP
 	TOTPSignIn
+	WebAuthnSignIn
 )
 
 func (authMethod AuthenticationMethod) String() string {
@@ -36,6 +40,8 @@ func (authMethod AuthenticationMethod) String() string {
 		return "otp"
 	case TOTPSignIn:
 		return "totp"
+	case WebAuthnSignIn:
+		return "webauthn"
 	}
 	return ""
 }
@@ -50,6 +56,15 @@ type Factor struct {
 	FriendlyName string    `json:"friendly_name,omitempty" db:"friendly_name"`
 	Secret       string    `json:"-" db:"secret"`
 	FactorType   string    `json:"factor_type" db:"factor_type"`
+
+	// The following are only populated for FactorType == WebAuthn.
+	WebAuthnCredentialID string `json:"-" db:"webauthn_credential_id"`
+	WebAuthnPublicKey    []byte `json:"-" db:"webauthn_public_key"`
+	WebAuthnAAGUID       string `json:"-" db:"webauthn_aaguid"`
+	WebAuthnSignCount    uint32 `json:"-" db:"webauthn_sign_count"`
+	// WebAuthnTransports is a comma-separated list (e.g. "usb,nfc") of the
+	// transports the authenticator reported supporting at registration.
+	WebAuthnTransports string `json:"-" db:"webauthn_transports"`
 }
 
 func (Factor) TableName() string {
@@ -73,6 +88,28 @@ func NewFactor(user *User, friendlyName string, factorType string, status, secre
 	return factor, nil
 }
 
+// NewWebAuthnFactor builds a Factor for a newly registered WebAuthn
+// credential. It starts out FactorStateUnverified until the registration
+// ceremony's attestation has been verified by the caller.
+func NewWebAuthnFactor(user *User, friendlyName string, credentialID string, publicKey []byte, aaguid string, transports string) (*Factor, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error generating unique id")
+	}
+	factor := &Factor{
+		UserID:               user.ID,
+		ID:                   id,
+		Status:               FactorStateUnverified,
+		FriendlyName:         friendlyName,
+		FactorType:           WebAuthn,
+		WebAuthnCredentialID: credentialID,
+		WebAuthnPublicKey:    publicKey,
+		WebAuthnAAGUID:       aaguid,
+		WebAuthnTransports:   transports,
+	}
+	return factor, nil
+}
+
 // FindFactorsByUser returns all factors belonging to a user ordered by timestamp
 func FindFactorsByUser(tx *storage.Connection, user *User) ([]*Factor, error) {
 	factors := []*Factor{}
@@ -93,6 +130,13 @@ func FindFactorByFactorID(tx *storage.Connection, factorID uuid.UUID) (*Factor,
 	return factor, nil
 }
 
+// FindFactorByCredentialID looks up the WebAuthn factor that owns
+// credentialID, used to resolve an assertion's credential id back to the
+// enrolled factor during sign-in.
+func FindFactorByCredentialID(tx *storage.Connection, credentialID string) (*Factor, error) {
+	return findFactor(tx, "factor_type = ? and webauthn_credential_id = ?", WebAuthn, credentialID)
+}
+
 func findFactor(tx *storage.Connection, query string, args ...interface{}) (*Factor, error) {
 	obj := &Factor{}
 	if err := tx.Eager().Q().Where(query, args...).First(obj); err != nil {
@@ -128,7 +172,17 @@ func (f *Factor) UpdateStatus(tx *storage.Connection, status string) error {
 	return tx.UpdateOnly(f, "status", "updated_at")
 }
 
-// IsMFAEnabled determines if user has met the conditions to activate MFA
+// UpdateWebAuthnSignCount persists the authenticator's signature counter
+// after a successful assertion, so a future replayed assertion with a
+// stale or non-increasing counter can be detected as cloned hardware.
+func (f *Factor) UpdateWebAuthnSignCount(tx *storage.Connection, signCount uint32) error {
+	f.WebAuthnSignCount = signCount
+	return tx.UpdateOnly(f, "webauthn_sign_count", "updated_at")
+}
+
+// IsMFAEnabled determines if user has met the conditions to activate MFA.
+// This counts verified factors of any FactorType - TOTP and WebAuthn are
+// equally sufficient to satisfy AAL2.
 func IsMFAEnabled(tx *storage.Connection, user *User) (bool, error) {
 	factors, err := FindVerifiedFactorsByUser(tx, user)
 	if err != nil {
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/netlify/gotrue/storage"
+	"github.com/pkg/errors"
+)
+
+// TokenType identifies which auth flow a Token was issued for.
+type TokenType string
+
+const (
+	ConfirmationToken       TokenType = "confirmation"
+	RecoveryToken           TokenType = "recovery"
+	InviteToken             TokenType = "invite"
+	ReauthenticationToken   TokenType = "reauthentication"
+	EmailChangeCurrentToken TokenType = "email_change_current"
+	EmailChangeNewToken     TokenType = "email_change_new"
+)
+
+// JSONMap is a free-form JSON object persisted as jsonb, used for the
+// per-token-type data a Token's payload carries (e.g. the new email address
+// for an email change token).
+type JSONMap map[string]interface{}
+
+func (m JSONMap) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *JSONMap) Scan(value interface{}) error {
+	if value == nil {
+		*m = nil
+		return nil
+	}
+	data, ok := value.([]byte)
+	if !ok {
+		return errors.New("models: JSONMap Scan source was not []byte")
+	}
+	return json.Unmarshal(data, m)
+}
+
+// Token is the unified store backing confirmation, recovery, invite,
+// reauthentication, and email-change tokens. The send* helpers in api still
+// write the per-type hashed columns on users alongside it; the store allows
+// multiple concurrent valid tokens and a TTL per token.
+type Token struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Type       TokenType  `json:"type" db:"type"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	Payload    JSONMap    `json:"payload,omitempty" db:"payload"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty" db:"consumed_at"`
+}
+
+func (Token) TableName() string {
+	return "tokens"
+}
+
+// IsExpired reports whether the token has passed its expiry time.
+func (t *Token) IsExpired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+// IsConsumed reports whether the token has already been used.
+func (t *Token) IsConsumed() bool {
+	return t.ConsumedAt != nil
+}
+
+// TokenNotFoundError is returned when a token lookup doesn't match any row.
+type TokenNotFoundError struct{}
+
+func (e TokenNotFoundError) Error() string {
+	return "Token not found"
+}
+
+// TokenStore persists and retrieves the unified tokens used across the
+// confirmation, recovery, invite, reauthentication, and email-change flows.
+type TokenStore struct{}
+
+func NewTokenStore() *TokenStore {
+	return &TokenStore{}
+}
+
+// Create issues a new token of the given type for user, expiring after ttl
+// (zero means it never expires).
+func (s *TokenStore) Create(tx *storage.Connection, user *User, tokenType TokenType, tokenHash string, payload JSONMap, ttl time.Duration) (*Token, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error generating unique id")
+	}
+
+	token := &Token{
+		ID:        id,
+		UserID:    user.ID,
+		Type:      tokenType,
+		TokenHash: tokenHash,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := token.CreatedAt.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := tx.Create(token); err != nil {
+		return nil, errors.Wrap(err, "Database error creating token")
+	}
+	return token, nil
+}
+
+// FindByHashAndType returns the most recently issued, unconsumed token
+// matching tokenHash and tokenType.
+func (s *TokenStore) FindByHashAndType(tx *storage.Connection, tokenHash string, tokenType TokenType) (*Token, error) {
+	token := &Token{}
+	err := tx.Q().Where(
+		"token_hash = ? and type = ? and consumed_at is null",
+		tokenHash, tokenType,
+	).Order("created_at desc").First(token)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, TokenNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "Database error finding token")
+	}
+	return token, nil
+}
+
+// Consume marks token as used so it cannot be redeemed again.
+func (s *TokenStore) Consume(tx *storage.Connection, token *Token) error {
+	now := time.Now()
+	token.ConsumedAt = &now
+	return errors.Wrap(tx.UpdateOnly(token, "consumed_at"), "Database error consuming token")
+}
+
+// PurgeExpired deletes every token past its expiry, including ones that
+// were never consumed. It's meant to be run periodically from a background
+// cleanup goroutine.
+func (s *TokenStore) PurgeExpired(tx *storage.Connection) error {
+	return tx.RawQuery("DELETE FROM " + (&Token{}).TableName() + " WHERE expires_at is not null and expires_at < now()").Exec()
+}
@@ -0,0 +1,49 @@
+// Package storage wraps the underlying pop/database connection used
+// throughout models and api so callers have a single place to add
+// context-awareness, transactions, and query helpers.
+package storage
+
+import (
+	"context"
+
+	"github.com/gobuffalo/pop/v5"
+)
+
+// Connection wraps a pop.Connection, threading a context.Context through
+// for cancellation and request-scoped values.
+type Connection struct {
+	*pop.Connection
+	context context.Context
+}
+
+func (c *Connection) WithContext(ctx context.Context) *Connection {
+	return &Connection{
+		Connection: c.Connection,
+		context:    ctx,
+	}
+}
+
+func (c *Connection) Context() context.Context {
+	if c.context == nil {
+		return context.Background()
+	}
+	return c.context
+}
+
+// Transaction runs fn inside a database transaction, rolling back if fn
+// returns an error.
+func (c *Connection) Transaction(fn func(*Connection) error) error {
+	if c.TX != nil {
+		return fn(c)
+	}
+	return c.Connection.Transaction(func(tx *pop.Connection) error {
+		return fn(&Connection{Connection: tx, context: c.context})
+	})
+}
+
+// UpdateOnly updates the given columns only, leaving the rest of the model
+// untouched - most model mutations in this codebase only ever change a
+// handful of columns at a time.
+func (c *Connection) UpdateOnly(model interface{}, columns ...string) error {
+	return c.Connection.UpdateColumns(model, columns...)
+}